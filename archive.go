@@ -0,0 +1,577 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xbsoftware/wfs"
+)
+
+// archiveKind identifies the container format of an archive file from its
+// name, or "" if the name isn't a recognized archive.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "targz"
+	case strings.HasSuffix(lower, ".tar.zst"):
+		return "tarzst"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+type ArchiveEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+	Date string `json:"date,omitempty"`
+}
+
+// archiveListHandler implements GET /archive?id=...&archive=1, listing
+// the entries of an archive file as a virtual folder without extracting
+// it to disk.
+func archiveListHandler(w http.ResponseWriter, r *http.Request) {
+	rawID := r.URL.Query().Get("id")
+	if rawID == "" || r.URL.Query().Get("archive") == "" {
+		format.Text(w, 400, "'id' and 'archive' parameters must be provided")
+		return
+	}
+
+	drive, id, err := resolveDrive(r, rawID)
+	if err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	info, err := drive.Info(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+	kind := archiveKind(info.Name)
+	if kind == "" {
+		format.Text(w, 400, "not an archive")
+		return
+	}
+	if info.Size > Config.UploadLimit {
+		format.Text(w, 500, "archive exceeds the upload limit")
+		return
+	}
+
+	content, err := drive.Read(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+
+	entries, err := listArchiveEntries(content, kind)
+	if err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	format.JSON(w, 200, entries)
+}
+
+func listArchiveEntries(content io.ReadSeeker, kind string) ([]ArchiveEntry, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ArchiveEntry, 0)
+	add := func(name string, size int64, isDir bool, modTime time.Time) {
+		if isArchiveTraversal(name) {
+			return
+		}
+		typ := "folder"
+		if !isDir {
+			typ = fileType(name)
+		}
+		out = append(out, ArchiveEntry{
+			Name: name,
+			Size: size,
+			Type: typ,
+			Date: modTime.UTC().Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	switch kind {
+	case "zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			add(f.Name, int64(f.UncompressedSize64), f.FileInfo().IsDir(), f.Modified)
+		}
+	case "tar", "targz", "tarzst":
+		tr, closer, err := tarReaderFor(bytes.NewReader(data), kind)
+		if err != nil {
+			return nil, err
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			add(hdr.Name, hdr.Size, hdr.Typeflag == tar.TypeDir, hdr.ModTime)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive kind %q", kind)
+	}
+
+	return out, nil
+}
+
+func tarReaderFor(r io.Reader, kind string) (*tar.Reader, io.Closer, error) {
+	switch kind {
+	case "targz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tar.NewReader(gz), gz, nil
+	case "tarzst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tar.NewReader(zr.IOReadCloser()), zr.IOReadCloser(), nil
+	default:
+		return tar.NewReader(r), nil, nil
+	}
+}
+
+// isArchiveTraversal guards against path traversal from crafted archive
+// entries: ".." segments and absolute paths are rejected.
+func isArchiveTraversal(name string) bool {
+	clean := path.Clean(name)
+	return clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean)
+}
+
+// directArchiveEntry implements the archive_entry query parameter on
+// /direct, streaming a single archive member without extracting the rest.
+func directArchiveEntry(w http.ResponseWriter, r *http.Request, drive wfs.Drive, id, entryPath string) {
+	info, err := drive.Info(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+	kind := archiveKind(info.Name)
+	if kind == "" {
+		format.Text(w, 400, "not an archive")
+		return
+	}
+	if info.Size > Config.UploadLimit {
+		format.Text(w, 500, "archive exceeds the upload limit")
+		return
+	}
+
+	content, err := drive.Read(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	entryData, modTime, err := readArchiveEntry(data, kind, entryPath)
+	if err != nil {
+		format.Text(w, 404, "entry not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(entryPath)))
+	http.ServeContent(w, r, path.Base(entryPath), modTime, bytes.NewReader(entryData))
+}
+
+func readArchiveEntry(data []byte, kind, entryPath string) ([]byte, time.Time, error) {
+	entryPath = path.Clean(entryPath)
+	if isArchiveTraversal(entryPath) {
+		return nil, time.Time{}, fmt.Errorf("invalid entry path")
+	}
+
+	switch kind {
+	case "zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		for _, f := range zr.File {
+			if path.Clean(f.Name) != entryPath {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			defer rc.Close()
+			out, err := io.ReadAll(rc)
+			return out, f.Modified, err
+		}
+	case "tar", "targz", "tarzst":
+		tr, closer, err := tarReaderFor(bytes.NewReader(data), kind)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if path.Clean(hdr.Name) != entryPath {
+				continue
+			}
+			out, err := io.ReadAll(tr)
+			return out, hdr.ModTime, err
+		}
+	}
+
+	return nil, time.Time{}, fmt.Errorf("entry not found")
+}
+
+type ExtractRequest struct {
+	ID      string
+	Target  string
+	Entries []string
+}
+
+// extractHandler implements POST /extract, unpacking a chosen subtree of
+// an archive into a destination folder.
+func extractHandler(w http.ResponseWriter, r *http.Request) {
+	data := ExtractRequest{}
+	if err := parseForm(w, r, &data); err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+	if data.ID == "" || data.Target == "" {
+		format.JSON(w, 500, Response{Error: "'id' and 'target' parameters must be provided"})
+		return
+	}
+
+	srcDrive, srcID, err := resolveDrive(r, data.ID)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+	dstDrive, dstID, err := resolveDrive(r, data.Target)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+
+	info, err := srcDrive.Info(srcID)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access denied"})
+		return
+	}
+	kind := archiveKind(info.Name)
+	if kind == "" {
+		format.JSON(w, 500, Response{Error: "not an archive"})
+		return
+	}
+	if info.Size > Config.UploadLimit {
+		format.JSON(w, 500, Response{Error: "archive exceeds the upload limit"})
+		return
+	}
+
+	content, err := srcDrive.Read(srcID)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access denied"})
+		return
+	}
+	raw, err := io.ReadAll(content)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+
+	if _, free, err := dstDrive.Stats(); err == nil && free > 0 && free != unlimitedFree && uint64(len(raw)) > free {
+		format.JSON(w, 500, Response{Error: "not enough free space"})
+		return
+	}
+
+	wanted := map[string]bool{}
+	for _, e := range data.Entries {
+		wanted[path.Clean(e)] = true
+	}
+
+	extracted := 0
+	err = walkArchive(raw, kind, func(name string, isDir bool, r io.Reader, size int64) error {
+		name = path.Clean(name)
+		if isArchiveTraversal(name) {
+			return nil
+		}
+		if len(wanted) > 0 && !archiveEntrySelected(wanted, name) {
+			return nil
+		}
+		if !isDir && size > Config.UploadLimit {
+			return fmt.Errorf("entry %q exceeds the upload limit", name)
+		}
+
+		parent, base := dstID, name
+		if dir := path.Dir(name); dir != "." {
+			parent = ensureArchiveFolder(dstDrive, dstID, dir)
+			base = path.Base(name)
+		}
+
+		id, err := dstDrive.Make(parent, base, isDir)
+		if err != nil {
+			return err
+		}
+		if !isDir {
+			// The declared header size can't be trusted - it's easy to
+			// fake a small one while the decompressor actually yields far
+			// more. Cap what's actually written and fail if the entry
+			// turns out to exceed the limit regardless of what it claimed.
+			counted := &countingReader{r: io.LimitReader(r, Config.UploadLimit+1)}
+			if err := dstDrive.Write(id, counted); err != nil {
+				return err
+			}
+			if counted.n > Config.UploadLimit {
+				dstDrive.Remove(id)
+				return fmt.Errorf("entry %q exceeds the upload limit", name)
+			}
+		}
+		extracted++
+		return nil
+	})
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+
+	format.JSON(w, 200, Response{Result: &Result{ID: dstID, Name: strconv.Itoa(extracted)}})
+}
+
+// countingReader tracks how many bytes have actually been read through
+// it, so callers can tell a reader capped with io.LimitReader actually
+// hit its limit rather than simply running out of input.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// archiveEntrySelected reports whether name is one of the wanted entries
+// or lives underneath one of the wanted folders.
+func archiveEntrySelected(wanted map[string]bool, name string) bool {
+	if wanted[name] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if wanted[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	archiveFolderCacheMu sync.Mutex
+	archiveFolderCache   = map[string]string{}
+)
+
+func ensureArchiveFolder(drive wfs.Drive, root, dir string) string {
+	key := root + "|" + dir
+
+	archiveFolderCacheMu.Lock()
+	defer archiveFolderCacheMu.Unlock()
+
+	if id, ok := archiveFolderCache[key]; ok {
+		return id
+	}
+
+	parent := root
+	built := ""
+	for _, part := range strings.Split(dir, "/") {
+		if part == "" {
+			continue
+		}
+		built = path.Join(built, part)
+		cacheKey := root + "|" + built
+		if id, ok := archiveFolderCache[cacheKey]; ok {
+			parent = id
+			continue
+		}
+		if !drive.Exists(parent + "/" + part) {
+			id, err := drive.Make(parent, part, true)
+			if err == nil {
+				parent = id
+			}
+		} else {
+			parent = parent + "/" + part
+		}
+		archiveFolderCache[cacheKey] = parent
+	}
+	return parent
+}
+
+func walkArchive(data []byte, kind string, visit func(name string, isDir bool, r io.Reader, size int64) error) error {
+	switch kind {
+	case "zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				if err := visit(f.Name, true, nil, 0); err != nil {
+					return err
+				}
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = visit(f.Name, false, rc, int64(f.UncompressedSize64))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	case "tar", "targz", "tarzst":
+		tr, closer, err := tarReaderFor(bytes.NewReader(data), kind)
+		if err != nil {
+			return err
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if hdr.Typeflag == tar.TypeDir {
+				if err := visit(hdr.Name, true, nil, 0); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := visit(hdr.Name, false, tr, hdr.Size); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported archive kind %q", kind)
+	}
+	return nil
+}
+
+type PackRequest struct {
+	Ids  []string
+	Name string
+}
+
+// packArchiveHandler implements POST /archive, streaming a zip of the
+// selected ids (files and, recursively, folders) straight to the client.
+func packArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	data := PackRequest{}
+	if err := parseForm(w, r, &data); err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+	if len(data.Ids) == 0 {
+		format.JSON(w, 500, Response{Error: "'ids' parameter must be provided"})
+		return
+	}
+
+	name := data.Name
+	if name == "" {
+		name = "archive.zip"
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	for _, rawID := range data.Ids {
+		drive, id, err := resolveDrive(r, rawID)
+		if err != nil {
+			continue
+		}
+		info, err := drive.Info(id)
+		if err != nil {
+			continue
+		}
+		if err := addToZip(zw, drive, id, info, ""); err != nil {
+			log.Printf("archive: failed to add %q: %s", id, err.Error())
+		}
+	}
+}
+
+func addToZip(zw *zip.Writer, drive wfs.Drive, id string, info wfs.File, prefix string) error {
+	name := path.Join(prefix, info.Name)
+
+	if info.Type == "folder" {
+		children, err := drive.List(id, &wfs.ListConfig{
+			SubFolders: false,
+			Exclude:    func(n string) bool { return strings.HasPrefix(n, ".") },
+		})
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := addToZip(zw, drive, child.ID, child, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	content, err := drive.Read(id)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, content)
+	return err
+}
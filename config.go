@@ -6,10 +6,30 @@ type ConfigServer struct {
 	Cors []string
 }
 
+// BackendConfig describes a single named storage backend that can be
+// mounted into the file manager. Type selects the driver ("localfs",
+// "s3" or "webdav"); the remaining fields are interpreted by that driver
+// and may be left empty when not relevant.
+type BackendConfig struct {
+	Name     string
+	Type     string
+	Root     string
+	Bucket   string
+	Region   string
+	Endpoint string
+	URL      string
+	User     string
+	Password string
+}
+
 type AppConfig struct {
-	Server      ConfigServer
-	Root        string
-	Preview     string
-	UploadLimit int64
-	Readonly    bool
+	Server       ConfigServer
+	Root         string
+	Preview      string
+	UploadLimit  int64
+	Readonly     bool
+	Backends     []BackendConfig
+	FFmpegPath   string
+	FFprobePath  string
+	PdftoppmPath string
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/xbsoftware/wfs"
+	local "github.com/xbsoftware/wfs-local"
+)
+
+// defaultBackend is the name used for the single filesystem backend that
+// is configured through the legacy -root flag / config.yml "root" value
+// when no explicit "backends" list is provided.
+const defaultBackend = "local"
+
+// drives holds every configured storage backend keyed by its name. File
+// ids exposed over the API are qualified as "{backend}:{id}" so that a
+// single flat id can be routed to the right Drive without carrying the
+// backend through every URL.
+var drives = map[string]wfs.Drive{}
+
+func driveConfigFor(backend BackendConfig) *wfs.DriveConfig {
+	cfg := &wfs.DriveConfig{Verbose: true}
+	cfg.Operation = &wfs.OperationConfig{PreventNameCollision: true}
+	if Config.Readonly {
+		policy := wfs.Policy(&wfs.ReadOnlyPolicy{})
+		cfg.Policy = &policy
+	}
+	return cfg
+}
+
+// initDrives builds the `drives` registry from Config.Backends, falling
+// back to a single "local" backend rooted at Config.Root for setups that
+// don't use the new multi-backend config.
+func initDrives() error {
+	if len(Config.Backends) == 0 {
+		Config.Backends = []BackendConfig{{Name: defaultBackend, Type: "localfs", Root: Config.Root}}
+	}
+
+	for _, b := range Config.Backends {
+		if b.Name == "" {
+			return fmt.Errorf("backend is missing a name")
+		}
+
+		driveConfig := driveConfigFor(b)
+
+		var (
+			drive wfs.Drive
+			err   error
+		)
+		switch b.Type {
+		case "", "localfs":
+			drive, err = local.NewLocalDrive(b.Root, driveConfig)
+		case "s3":
+			drive, err = newS3Drive(b, driveConfig)
+		case "webdav":
+			drive, err = newWebDAVDrive(b, driveConfig)
+		default:
+			return fmt.Errorf("backend %q has an unknown type %q", b.Name, b.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("backend %q: %w", b.Name, err)
+		}
+
+		drives[b.Name] = &indexedDrive{Drive: drive, backend: b.Name}
+	}
+
+	return nil
+}
+
+// indexedDrive wraps a backend Drive so writes go through the search
+// index, keeping it fresh without every call site having to remember to
+// update it. Reads (List/Info/Read/Exists/Stats) pass straight through
+// via the embedded Drive.
+type indexedDrive struct {
+	wfs.Drive
+	backend string
+}
+
+func (d *indexedDrive) Write(id string, data io.Reader) error {
+	err := d.Drive.Write(id, data)
+	if err == nil {
+		index.reindexOne(d.backend, id, d.Drive)
+	}
+	return err
+}
+
+func (d *indexedDrive) Make(id string, name string, isFolder bool) (string, error) {
+	newID, err := d.Drive.Make(id, name, isFolder)
+	if err == nil {
+		index.reindexOne(d.backend, newID, d.Drive)
+	}
+	return newID, err
+}
+
+func (d *indexedDrive) Move(id string, target string, newName string) (string, error) {
+	newID, err := d.Drive.Move(id, target, newName)
+	if err == nil {
+		index.remove(joinID(d.backend, id))
+		index.reindexOne(d.backend, newID, d.Drive)
+	}
+	return newID, err
+}
+
+func (d *indexedDrive) Copy(id string, target string, newName string) (string, error) {
+	newID, err := d.Drive.Copy(id, target, newName)
+	if err == nil {
+		index.reindexOne(d.backend, newID, d.Drive)
+	}
+	return newID, err
+}
+
+func (d *indexedDrive) Remove(id string) error {
+	err := d.Drive.Remove(id)
+	if err == nil {
+		index.remove(joinID(d.backend, id))
+	}
+	return err
+}
+
+func joinID(backend, id string) string {
+	return backend + ":" + id
+}
+
+// splitID separates the backend name from a qualified id. Plain ids with
+// no "backend:" prefix are treated as belonging to the default backend,
+// which keeps single-backend deployments and existing clients working.
+func splitID(id string) (string, string) {
+	backend, rest, ok := strings.Cut(id, ":")
+	if !ok {
+		return defaultBackend, id
+	}
+	if _, known := drives[backend]; !known {
+		return defaultBackend, id
+	}
+	return backend, rest
+}
+
+// resolveDrive finds the Drive and inner id addressed by a qualified id.
+// When a {backend} URL param is present (routes mounted as .../{backend})
+// it takes precedence and the id is used as-is, unqualified.
+func resolveDrive(r *http.Request, id string) (wfs.Drive, string, error) {
+	backend := chi.URLParam(r, "backend")
+	inner := id
+	if backend == "" {
+		backend, inner = splitID(id)
+	}
+
+	drive, ok := drives[backend]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown backend %q", backend)
+	}
+	return drive, inner, nil
+}
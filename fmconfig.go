@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xbsoftware/wfs"
+)
+
+// fmConfigFile is the per-folder override file, read when listing or
+// writing into a directory and merged with the global AppConfig - the
+// same pattern gohttpserver uses for its ".ghs.yml".
+const fmConfigFile = ".fmconfig.yml"
+
+// FolderConfig is the result of merging every fmConfigFile from the
+// backend root down to a given folder, child overriding parent. Pointer
+// and zero-value fields mean "not set at this level".
+type FolderConfig struct {
+	Readonly          *bool
+	UploadLimit       int64
+	AllowedExtensions []string
+	Preview           string
+	QuotaBytes        int64
+	Hidden            bool
+}
+
+type rawFolderConfig struct {
+	Readonly          *bool    `yaml:"readonly"`
+	UploadLimit       string   `yaml:"upload_limit"`
+	AllowedExtensions []string `yaml:"allowed_extensions"`
+	Preview           string   `yaml:"preview"`
+	QuotaBytes        string   `yaml:"quota_bytes"`
+	Hidden            *bool    `yaml:"hidden"`
+}
+
+type cachedFolderConfig struct {
+	mtime int64
+	cfg   *rawFolderConfig
+}
+
+var fmConfigCache = struct {
+	mu      sync.Mutex
+	entries map[string]cachedFolderConfig
+}{entries: map[string]cachedFolderConfig{}}
+
+// folderConfigAt reads and parses the fmConfigFile directly inside dirID,
+// if any, using an mtime-checked cache to avoid re-parsing on every
+// request.
+func folderConfigAt(drive wfs.Drive, backend, dirID string) *rawFolderConfig {
+	configID := path.Join(dirID, fmConfigFile)
+	cacheKey := backend + ":" + configID
+
+	info, err := drive.Info(configID)
+	if err != nil {
+		fmConfigCache.mu.Lock()
+		delete(fmConfigCache.entries, cacheKey)
+		fmConfigCache.mu.Unlock()
+		return nil
+	}
+
+	fmConfigCache.mu.Lock()
+	cached, ok := fmConfigCache.entries[cacheKey]
+	fmConfigCache.mu.Unlock()
+	if ok && cached.mtime == info.Date {
+		return cached.cfg
+	}
+
+	content, err := drive.Read(configID)
+	if err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil
+	}
+
+	cfg := &rawFolderConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil
+	}
+
+	fmConfigCache.mu.Lock()
+	fmConfigCache.entries[cacheKey] = cachedFolderConfig{mtime: info.Date, cfg: cfg}
+	fmConfigCache.mu.Unlock()
+
+	return cfg
+}
+
+// effectiveFolderConfig composes the fmConfigFile overrides from the
+// backend root down to dirID, with deeper folders overriding shallower
+// ones.
+func effectiveFolderConfig(drive wfs.Drive, backend, dirID string) FolderConfig {
+	out := FolderConfig{}
+
+	parts := strings.Split(strings.Trim(dirID, "/"), "/")
+	dir := ""
+	dirs := []string{""}
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		dirs = append(dirs, dir)
+	}
+
+	for _, d := range dirs {
+		raw := folderConfigAt(drive, backend, d)
+		if raw == nil {
+			continue
+		}
+		if raw.Readonly != nil {
+			out.Readonly = raw.Readonly
+		}
+		if raw.Hidden != nil {
+			out.Hidden = *raw.Hidden
+		}
+		if raw.Preview != "" {
+			out.Preview = raw.Preview
+		}
+		if raw.AllowedExtensions != nil {
+			out.AllowedExtensions = raw.AllowedExtensions
+		}
+		if raw.UploadLimit != "" {
+			if size, err := parseByteSize(raw.UploadLimit); err == nil {
+				out.UploadLimit = size
+			}
+		}
+		if raw.QuotaBytes != "" {
+			if size, err := parseByteSize(raw.QuotaBytes); err == nil {
+				out.QuotaBytes = size
+			}
+		}
+	}
+
+	return out
+}
+
+func (c FolderConfig) isReadonly() bool {
+	return c.Readonly != nil && *c.Readonly
+}
+
+func (c FolderConfig) extensionAllowed(name string) bool {
+	if len(c.AllowedExtensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, allowed := range c.AllowedExtensions {
+		if strings.ToLower(allowed) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// filterListing applies a folder's effective config to its own listing:
+// the folder is rejected outright when marked hidden, files with a
+// disallowed extension are dropped, and any child folder that carries
+// its own hidden: true (a deeper .fmconfig.yml than dirID's) is dropped
+// too - otherwise it would still show up by name here even though
+// browsing into it directly is denied.
+func filterListing(drive wfs.Drive, backend, dirID string, files []wfs.File) ([]wfs.File, error) {
+	cfg := effectiveFolderConfig(drive, backend, dirID)
+	if cfg.Hidden {
+		return nil, fmt.Errorf("access denied")
+	}
+
+	out := make([]wfs.File, 0, len(files))
+	for _, f := range files {
+		if f.Type != "folder" {
+			if len(cfg.AllowedExtensions) > 0 && !cfg.extensionAllowed(f.Name) {
+				continue
+			}
+			out = append(out, f)
+			continue
+		}
+		if effectiveFolderConfig(drive, backend, f.ID).Hidden {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// parseByteSize parses sizes like "512", "50MB" or "10GB".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	multiplier := int64(1)
+
+	switch {
+	case strings.HasSuffix(s, "GB"):
+		multiplier = 1 << 30
+		s = strings.TrimSuffix(s, "GB")
+	case strings.HasSuffix(s, "MB"):
+		multiplier = 1 << 20
+		s = strings.TrimSuffix(s, "MB")
+	case strings.HasSuffix(s, "KB"):
+		multiplier = 1 << 10
+		s = strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "B"):
+		s = strings.TrimSuffix(s, "B")
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value * multiplier, nil
+}
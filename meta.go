@@ -28,11 +28,17 @@ type FolderInfo struct {
 }
 
 func getMetaInfo(w http.ResponseWriter, r *http.Request) {
-	id, err := url.QueryUnescape(chi.URLParam(r, "id"))
+	rawID, err := url.QueryUnescape(chi.URLParam(r, "id"))
 	if err != nil {
 		format.JSON(w, 500, Response{Error: "id not provided"})
 	}
 
+	drive, id, err := resolveDrive(r, rawID)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access denied"})
+		return
+	}
+
 	var info wfs.File
 	deadline := time.Now().Add(10 * time.Second)
 	for {
@@ -50,11 +56,11 @@ func getMetaInfo(w http.ResponseWriter, r *http.Request) {
 
 	var meta interface{}
 	if info.Type == "audio" {
-		meta, err = getMusicMetaInfo(id)
+		meta, err = getMusicMetaInfo(drive, id)
 	} else if info.Type == "image" {
-		meta, err = getImageMetaInfo(id)
+		meta, err = getImageMetaInfo(drive, id)
 	} else if info.Type == "folder" {
-		meta, err = getFolderInfo(id)
+		meta, err = getFolderInfo(drive, id)
 	} else {
 		meta = nil
 	}
@@ -66,7 +72,7 @@ func getMetaInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getMusicMetaInfo(id string) (MusicMeta, error) {
+func getMusicMetaInfo(drive wfs.Drive, id string) (MusicMeta, error) {
 	content, err := drive.Read(id)
 	if err != nil {
 		return MusicMeta{}, err
@@ -86,7 +92,7 @@ func getMusicMetaInfo(id string) (MusicMeta, error) {
 	}, nil
 }
 
-func getImageMetaInfo(id string) (map[exif.FieldName]string, error) {
+func getImageMetaInfo(drive wfs.Drive, id string) (map[exif.FieldName]string, error) {
 	data, err := drive.Read(id)
 	if err != nil {
 		return nil, err
@@ -104,9 +110,9 @@ func getImageMetaInfo(id string) (map[exif.FieldName]string, error) {
 	return exifmap, nil
 }
 
-func getFolderInfo(id string) (FolderInfo, error) {
+func getFolderInfo(drive wfs.Drive, id string) (FolderInfo, error) {
 	var size int64
-	size, count, err := checkoutDir(id, &size)
+	size, count, err := checkoutDir(drive, id, &size)
 	if err != nil {
 		return FolderInfo{}, err
 	}
@@ -117,7 +123,7 @@ func getFolderInfo(id string) (FolderInfo, error) {
 	}, nil
 }
 
-func checkoutDir(id string, total *int64) (int64, int, error) {
+func checkoutDir(drive wfs.Drive, id string, total *int64) (int64, int, error) {
 	dir, err := drive.List(id, &wfs.ListConfig{
 		SubFolders: false,
 		Exclude:    func(name string) bool { return strings.HasPrefix(name, ".") },
@@ -128,7 +134,7 @@ func checkoutDir(id string, total *int64) (int64, int, error) {
 
 	for _, f := range dir {
 		if f.Type == "folder" {
-			t, _, err := checkoutDir(f.ID, total)
+			t, _, err := checkoutDir(drive, f.ID, total)
 			if err != nil {
 				return 0, 0, err
 			}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// previewMetrics tracks preview generation outcomes for the /metrics
+// endpoint: how many previews the background worker pool rendered, how
+// many were served from cache, and how many background renders failed.
+// generated and failed count only video and PDF previews; image previews
+// and previews from the external service are not counted there, while
+// cached counts every preview served from the on-disk cache.
+var previewMetrics = struct {
+	generated atomic.Uint64
+	cached    atomic.Uint64
+	failed    atomic.Uint64
+}{}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP filemanager_preview_generated_total Video and PDF previews rendered by the background worker pool.\n")
+	fmt.Fprintf(w, "# TYPE filemanager_preview_generated_total counter\n")
+	fmt.Fprintf(w, "filemanager_preview_generated_total %d\n", previewMetrics.generated.Load())
+
+	fmt.Fprintf(w, "# HELP filemanager_preview_cached_total Previews served from the on-disk cache.\n")
+	fmt.Fprintf(w, "# TYPE filemanager_preview_cached_total counter\n")
+	fmt.Fprintf(w, "filemanager_preview_cached_total %d\n", previewMetrics.cached.Load())
+
+	fmt.Fprintf(w, "# HELP filemanager_preview_failed_total Background video and PDF preview renders that failed.\n")
+	fmt.Fprintf(w, "# TYPE filemanager_preview_failed_total counter\n")
+	fmt.Fprintf(w, "filemanager_preview_failed_total %d\n", previewMetrics.failed.Load())
+}
@@ -8,6 +8,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -17,6 +18,11 @@ import (
 	"github.com/xbsoftware/wfs"
 )
 
+// previewCacheDir holds rendered thumbnails for every backend, keyed by
+// backend name and file id. It is independent of any backend's own
+// storage so that remote backends (S3, WebDAV) can be previewed too.
+var previewCacheDir = filepath.Join(os.TempDir(), "fm-previews")
+
 func getIconURL(name string, size string) string {
 	var re = regexp.MustCompile(`[^A-Za-z0-9.]`)
 	name = re.ReplaceAllString(name, "")
@@ -46,7 +52,7 @@ func getFilePreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.URL.Query().Get("id")
+	rawID := r.URL.Query().Get("id")
 	widthStr := r.URL.Query().Get("width")
 	heightStr := r.URL.Query().Get("height")
 	width, err := strconv.Atoi(widthStr)
@@ -58,6 +64,17 @@ func getFilePreview(w http.ResponseWriter, r *http.Request) {
 		height = 163
 	}
 
+	drive, id, err := resolveDrive(r, rawID)
+	if err != nil {
+		serveIconPreview(w, r, nil)
+		return
+	}
+	backend, _ := splitID(rawID)
+	if effectiveFolderConfig(drive, backend, path.Dir(id)).Preview == "none" {
+		format.Text(w, 500, "Previews not configured")
+		return
+	}
+
 	var info wfs.File
 	deadline := time.Now().Add(10 * time.Second)
 	for {
@@ -78,10 +95,8 @@ func getFilePreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	source := filepath.Join(Config.Root, id)
-	name := filepath.Base(source)
-	folder := filepath.Join(Config.Root, id[:len(id)-len(name)], ".preview")
-	preview := filepath.Join(folder, name+"___"+widthStr+"x"+heightStr)
+	folder := filepath.Join(previewCacheDir, backend, filepath.Dir(id), ".preview")
+	preview := filepath.Join(folder, filepath.Base(id)+"___"+widthStr+"x"+heightStr)
 
 	// check previously generated preview
 	ext := ".jpg"
@@ -96,24 +111,46 @@ func getFilePreview(w http.ResponseWriter, r *http.Request) {
 			serveIconPreview(w, r, &info)
 			return
 		} else {
+			previewMetrics.cached.Add(1)
 			http.ServeFile(w, r, preview+ext)
 		}
 		return
 	}
 
 	// ensure that preview folder does exist
-	os.Mkdir(folder, 0777)
+	os.MkdirAll(folder, 0777)
 
 	if Config.Preview != "" {
 		file, _ := drive.Read(id)
 		if x, ok := file.(io.Closer); ok {
 			defer x.Close()
 		}
-		ext, err = getExternalPreview(file, preview, name, widthStr, heightStr)
-	} else {
-		if info.Type == "image" {
-			ext, err = getImagePreview(source, width, height, preview)
+		ext, err = getExternalPreview(file, preview, filepath.Base(id), widthStr, heightStr)
+	} else if info.Type == "image" {
+		file, readErr := drive.Read(id)
+		if readErr != nil {
+			err = readErr
+		} else {
+			ext, err = getImagePreview(file, width, height, preview)
 		}
+	} else if info.Type == "video" || (info.Type == "document" && pdftoppmAvailable()) {
+		// rendering a video frame or a PDF page can take a while, so it
+		// runs on a bounded worker pool and the client polls back for it
+		queuePreviewGeneration(backend+":"+id, preview, func() (string, error) {
+			file, readErr := drive.Read(id)
+			if readErr != nil {
+				return "", readErr
+			}
+			if x, ok := file.(io.Closer); ok {
+				defer x.Close()
+			}
+			if info.Type == "video" {
+				return getVideoPreview(file, width, height, preview)
+			}
+			return getDocumentPreview(file, width, preview)
+		})
+		respondPreviewPending(w)
+		return
 	}
 
 	if err != nil {
@@ -125,8 +162,8 @@ func getFilePreview(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, preview+ext)
 }
 
-func getImagePreview(source string, width, height int, preview string) (string, error) {
-	src, err := imaging.Open(source)
+func getImagePreview(source io.Reader, width, height int, preview string) (string, error) {
+	src, err := imaging.Decode(source)
 	if err != nil {
 		return "", err
 	}
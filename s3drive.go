@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/xbsoftware/wfs"
+)
+
+// s3Drive implements wfs.Drive on top of an S3-compatible bucket. Folders
+// don't exist as first class objects in S3, so they are represented as
+// zero-byte objects with a trailing "/" in their key, the same convention
+// used by the AWS console and most S3 browsers.
+type s3Drive struct {
+	client *s3.Client
+	bucket string
+	config *wfs.DriveConfig
+}
+
+func newS3Drive(b BackendConfig, driveConfig *wfs.DriveConfig) (wfs.Drive, error) {
+	if b.Bucket == "" {
+		return nil, fmt.Errorf("s3 backend requires a bucket")
+	}
+
+	opts := []func(*config.LoadOptions) error{}
+	if b.Region != "" {
+		opts = append(opts, config.WithRegion(b.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if b.Endpoint != "" {
+			o.BaseEndpoint = aws.String(b.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &s3Drive{client: client, bucket: b.Bucket, config: driveConfig}, nil
+}
+
+func (d *s3Drive) key(id string) string {
+	return strings.TrimPrefix(path.Clean("/"+id), "/")
+}
+
+func (d *s3Drive) List(id string, cfg *wfs.ListConfig) ([]wfs.File, error) {
+	prefix := d.key(id)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	out := make([]wfs.File, 0)
+	seen := map[string]bool{}
+
+	var delimiter *string
+	if cfg == nil || !cfg.SubFolders {
+		delimiter = aws.String("/")
+	}
+
+	var token *string
+	for {
+		res, err := d.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(d.bucket),
+			Prefix:            aws.String(prefix),
+			Delimiter:         delimiter,
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cp := range res.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(cp.Prefix), prefix), "/")
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if !matchesFilter(cfg, name) {
+				continue
+			}
+			out = append(out, wfs.File{ID: aws.ToString(cp.Prefix), Name: name, Type: "folder"})
+		}
+
+		for _, obj := range res.Contents {
+			key := aws.ToString(obj.Key)
+			if key == prefix || strings.HasSuffix(key, "/") {
+				continue
+			}
+			name := strings.TrimPrefix(key, prefix)
+			if cfg != nil && cfg.SubFolders {
+				name = path.Base(key)
+			} else if strings.Contains(name, "/") {
+				continue
+			}
+			if !matchesFilter(cfg, name) {
+				continue
+			}
+			out = append(out, wfs.File{
+				ID:   key,
+				Name: name,
+				Type: fileType(name),
+				Size: aws.ToInt64(obj.Size),
+				Date: obj.LastModified.Unix(),
+			})
+		}
+
+		if res.IsTruncated == nil || !*res.IsTruncated {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	return out, nil
+}
+
+func matchesFilter(cfg *wfs.ListConfig, name string) bool {
+	if cfg == nil {
+		return true
+	}
+	if cfg.Exclude != nil && cfg.Exclude(name) {
+		return false
+	}
+	if cfg.Include != nil && !cfg.Include(name) {
+		return false
+	}
+	return true
+}
+
+func (d *s3Drive) Info(id string) (wfs.File, error) {
+	key := d.key(id)
+	if key == "" {
+		return wfs.File{ID: "", Name: "/", Type: "folder"}, nil
+	}
+
+	head, err := d.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// HeadObject 404s for prefixes that only exist implicitly as folders.
+		if d.isFolder(key) {
+			return wfs.File{ID: key + "/", Name: path.Base(key), Type: "folder"}, nil
+		}
+		return wfs.File{}, err
+	}
+
+	return wfs.File{
+		ID:   key,
+		Name: path.Base(key),
+		Type: fileType(key),
+		Size: aws.ToInt64(head.ContentLength),
+		Date: head.LastModified.Unix(),
+	}, nil
+}
+
+func (d *s3Drive) isFolder(key string) bool {
+	res, err := d.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(d.bucket),
+		Prefix:  aws.String(key + "/"),
+		MaxKeys: aws.Int32(1),
+	})
+	return err == nil && len(res.Contents) > 0
+}
+
+func (d *s3Drive) Read(id string) (io.ReadSeeker, error) {
+	key := d.key(id)
+
+	info, err := d.Info(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3ObjectReader{client: d.client, bucket: d.bucket, key: key, size: info.Size}, nil
+}
+
+// s3ObjectReader is a lazy io.ReadSeeker over an S3 object: it only issues
+// a GetObject call (with an explicit byte Range) when Read is first
+// called or after a Seek lands somewhere other than the current stream
+// position, instead of buffering the whole object upfront. This lets
+// http.ServeContent answer byte-range requests (video/PDF seeking) and
+// serve large files without holding them in memory.
+type s3ObjectReader struct {
+	client *s3.Client
+	bucket string
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("s3: invalid whence")
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("s3: negative seek position")
+	}
+	if abs != r.offset {
+		r.closeBody()
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *s3ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		res, err := r.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(r.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", r.offset, r.size-1)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.body = res.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF {
+		r.closeBody()
+	}
+	return n, err
+}
+
+func (r *s3ObjectReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+func (d *s3Drive) Write(id string, data io.Reader) error {
+	uploader := manager.NewUploader(d.client)
+	_, err := uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.key(id)),
+		Body:   data,
+	})
+	return err
+}
+
+func (d *s3Drive) Make(id string, name string, isFolder bool) (string, error) {
+	key := d.key(path.Join(id, name))
+	if isFolder {
+		key += "/"
+	}
+
+	_, err := d.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(nil),
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// isFolderID reports whether id addresses a folder rather than a file.
+// Every folder id this drive ever hands out (List's CommonPrefixes,
+// Info, Make) ends in "/"; file ids never do.
+func isFolderID(id string) bool {
+	return strings.HasSuffix(id, "/")
+}
+
+func (d *s3Drive) Copy(id string, target string, newName string) (string, error) {
+	src := d.key(id)
+	name := newName
+	if name == "" {
+		name = path.Base(src)
+	}
+	// An empty target means "keep the current parent" (a plain rename),
+	// matching the newName == "" fallback above.
+	if target == "" {
+		target = path.Dir(src)
+	}
+
+	if isFolderID(id) {
+		return d.copyFolder(src, target, name)
+	}
+
+	dst := d.key(path.Join(target, name))
+
+	_, err := d.client.CopyObject(context.Background(), &s3.CopyObjectInput{
+		Bucket:     aws.String(d.bucket),
+		Key:        aws.String(dst),
+		CopySource: aws.String(d.bucket + "/" + src),
+	})
+	if err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// copyFolder recursively copies every object under the "srcKey/" prefix
+// to "target/name/", preserving the subtree structure, and returns the
+// new folder id. CopyObject only ever copies a single key, so a folder
+// (which has no object of its own beyond its zero-byte marker) has to be
+// copied one descendant at a time.
+func (d *s3Drive) copyFolder(srcKey, target, name string) (string, error) {
+	srcPrefix := srcKey + "/"
+	dstPrefix := d.key(path.Join(target, name)) + "/"
+
+	var token *string
+	for {
+		res, err := d.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(d.bucket),
+			Prefix:            aws.String(srcPrefix),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, obj := range res.Contents {
+			key := aws.ToString(obj.Key)
+			dstKey := dstPrefix + strings.TrimPrefix(key, srcPrefix)
+			if _, err := d.client.CopyObject(context.Background(), &s3.CopyObjectInput{
+				Bucket:     aws.String(d.bucket),
+				Key:        aws.String(dstKey),
+				CopySource: aws.String(d.bucket + "/" + key),
+			}); err != nil {
+				return "", err
+			}
+		}
+		if res.IsTruncated == nil || !*res.IsTruncated {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	// Make sure the destination folder marker exists even if the source
+	// folder was empty (no descendants were copied above).
+	_, err := d.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(dstPrefix),
+		Body:   bytes.NewReader(nil),
+	})
+	if err != nil {
+		return "", err
+	}
+	return dstPrefix, nil
+}
+
+func (d *s3Drive) Move(id string, target string, newName string) (string, error) {
+	dst, err := d.Copy(id, target, newName)
+	if err != nil {
+		return "", err
+	}
+	if err := d.Remove(id); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (d *s3Drive) Remove(id string) error {
+	key := d.key(id)
+
+	// Check for an exact-key file first. Listing with Prefix: key alone
+	// would also match sibling keys that merely start with the same
+	// string (e.g. "report.pdf" matching "report.pdf.bak"), so folder
+	// deletion below is only attempted under the "key/" boundary.
+	if _, err := d.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(key),
+	}); err == nil {
+		_, err := d.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+			Bucket: aws.String(d.bucket),
+			Key:    aws.String(key),
+		})
+		return err
+	}
+
+	prefix := key + "/"
+	var objects []types.ObjectIdentifier
+	var token *string
+	for {
+		res, err := d.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(d.bucket),
+			Prefix:            aws.String(prefix),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return err
+		}
+		for _, obj := range res.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+		}
+		if res.IsTruncated == nil || !*res.IsTruncated {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	return d.deleteObjects(objects)
+}
+
+// s3DeleteBatchSize is the maximum number of keys S3's DeleteObjects
+// accepts in a single request.
+const s3DeleteBatchSize = 1000
+
+func (d *s3Drive) deleteObjects(objects []types.ObjectIdentifier) error {
+	for len(objects) > 0 {
+		n := s3DeleteBatchSize
+		if n > len(objects) {
+			n = len(objects)
+		}
+		_, err := d.client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(d.bucket),
+			Delete: &types.Delete{Objects: objects[:n]},
+		})
+		if err != nil {
+			return err
+		}
+		objects = objects[n:]
+	}
+	return nil
+}
+
+func (d *s3Drive) Exists(id string) bool {
+	_, err := d.Info(id)
+	return err == nil
+}
+
+func (d *s3Drive) Stats() (uint64, uint64, error) {
+	// S3 buckets have no fixed capacity; report used space only and use
+	// the unlimitedFree sentinel so callers never fold it into a sum with
+	// another backend's real free-space value.
+	var used uint64
+	var token *string
+	for {
+		res, err := d.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(d.bucket),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, obj := range res.Contents {
+			used += uint64(aws.ToInt64(obj.Size))
+		}
+		if res.IsTruncated == nil || !*res.IsTruncated {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	return used, unlimitedFree, nil
+}
+
+func fileType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg":
+		return "image"
+	case ".mp3", ".wav", ".flac", ".ogg":
+		return "audio"
+	case ".mp4", ".webm", ".mov", ".avi", ".mkv":
+		return "video"
+	}
+	return "file"
+}
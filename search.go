@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xbsoftware/wfs"
+)
+
+// searchIndexPath is where the in-memory index is persisted between
+// restarts so large trees don't need a full walk on every cold start.
+var searchIndexPath = filepath.Join(os.TempDir(), "fm-search-index.json")
+
+const searchRefreshInterval = 10 * time.Minute
+
+var searchWordRe = regexp.MustCompile(`[A-Za-z0-9]+`)
+
+// IndexedFile is the flattened, persistable record kept for every file
+// and folder across all backends.
+type IndexedFile struct {
+	ID      string `json:"id"`
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Size    int64  `json:"size"`
+	Date    int64  `json:"date"`
+}
+
+// searchIndex is a simple in-memory inverted index: each lowercase word
+// found in a file name maps to the set of composite ids ("backend:id")
+// containing it. It's intentionally a hand-rolled word index rather than
+// a trigram or external engine, matching the scale this module runs at.
+type searchIndex struct {
+	mu    sync.RWMutex
+	words map[string]map[string]bool
+	docs  map[string]IndexedFile
+	ready bool
+}
+
+// isReady reports whether the index has completed at least one full
+// build (or load from disk). Search results are meaningless before that,
+// since the index would just be empty.
+func (idx *searchIndex) isReady() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.ready
+}
+
+func (idx *searchIndex) markReady() {
+	idx.mu.Lock()
+	idx.ready = true
+	idx.mu.Unlock()
+}
+
+var index = &searchIndex{
+	words: map[string]map[string]bool{},
+	docs:  map[string]IndexedFile{},
+}
+
+func tokenize(name string) []string {
+	return searchWordRe.FindAllString(strings.ToLower(name), -1)
+}
+
+func (idx *searchIndex) put(doc IndexedFile) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(doc.ID)
+	idx.docs[doc.ID] = doc
+	for _, word := range tokenize(doc.Name) {
+		set, ok := idx.words[word]
+		if !ok {
+			set = map[string]bool{}
+			idx.words[word] = set
+		}
+		set[doc.ID] = true
+	}
+}
+
+func (idx *searchIndex) remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(id)
+}
+
+func (idx *searchIndex) removeLocked(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, word := range tokenize(doc.Name) {
+		if set, ok := idx.words[word]; ok {
+			delete(set, id)
+			if len(set) == 0 {
+				delete(idx.words, word)
+			}
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// underHiddenFolder reports whether f itself, or any of its ancestor
+// folders, is marked hidden: true in a .fmconfig.yml - the same rule
+// filterListing uses to keep a folder out of its parent's listing. The
+// index must honor it too, or /search exposes hidden trees that /files
+// correctly hides.
+func underHiddenFolder(drive wfs.Drive, backend string, f wfs.File) bool {
+	dirID := f.ID
+	if f.Type != "folder" {
+		dirID = path.Dir(f.ID)
+	}
+	return effectiveFolderConfig(drive, backend, dirID).Hidden
+}
+
+// reindexOne refreshes a single entry after a write/make/move/copy, so
+// that common edits show up in search immediately rather than waiting
+// for the periodic refresh.
+func (idx *searchIndex) reindexOne(backend, id string, drive wfs.Drive) {
+	info, err := drive.Info(id)
+	if err != nil {
+		return
+	}
+	if underHiddenFolder(drive, backend, info) {
+		idx.remove(joinID(backend, info.ID))
+		return
+	}
+	idx.put(IndexedFile{
+		ID:      joinID(backend, info.ID),
+		Backend: backend,
+		Path:    info.ID,
+		Name:    info.Name,
+		Type:    info.Type,
+		Size:    info.Size,
+		Date:    info.Date,
+	})
+}
+
+type SearchHit struct {
+	IndexedFile
+	Score int `json:"score"`
+}
+
+type SearchFacets struct {
+	Type map[string]int `json:"type"`
+}
+
+type SearchResponse struct {
+	Hits   []SearchHit  `json:"hits"`
+	Facets SearchFacets `json:"facets"`
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if !index.isReady() {
+		format.JSON(w, 503, Response{Error: "index not ready yet"})
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		format.JSON(w, 400, Response{Error: "'q' parameter must be provided"})
+		return
+	}
+	typeFilter := r.URL.Query().Get("type")
+
+	var modifiedAfter int64
+	if raw := r.URL.Query().Get("modified_after"); raw != "" {
+		if ts, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			modifiedAfter = ts
+		}
+	}
+
+	hits, facets := index.search(q, typeFilter, modifiedAfter)
+	format.JSON(w, 200, SearchResponse{Hits: hits, Facets: facets})
+}
+
+func (idx *searchIndex) search(q, typeFilter string, modifiedAfter int64) ([]SearchHit, SearchFacets) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	words := tokenize(q)
+	scores := map[string]int{}
+
+	if len(words) == 0 {
+		return []SearchHit{}, SearchFacets{Type: map[string]int{}}
+	}
+
+	for _, word := range words {
+		for id := range idx.words[word] {
+			scores[id]++
+		}
+		// fall back to substring matching for partial words, e.g. "repor"
+		// matching "report", which a pure word index would otherwise miss.
+		for token, ids := range idx.words {
+			if token == word || !strings.Contains(token, word) {
+				continue
+			}
+			for id := range ids {
+				scores[id]++
+			}
+		}
+	}
+
+	facets := SearchFacets{Type: map[string]int{}}
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		doc, ok := idx.docs[id]
+		if !ok {
+			continue
+		}
+		if typeFilter != "" && doc.Type != typeFilter {
+			continue
+		}
+		if modifiedAfter != 0 && doc.Date < modifiedAfter {
+			continue
+		}
+		facets.Type[doc.Type]++
+		hits = append(hits, SearchHit{IndexedFile: doc, Score: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Name < hits[j].Name
+	})
+
+	return hits, facets
+}
+
+// buildSearchIndex walks every backend from the root and (re)populates
+// the index from scratch.
+func buildSearchIndex() {
+	fresh := &searchIndex{words: map[string]map[string]bool{}, docs: map[string]IndexedFile{}}
+
+	for name, drive := range drives {
+		files, err := drive.List("/", &wfs.ListConfig{
+			SubFolders: true,
+			Exclude:    func(n string) bool { return strings.HasPrefix(n, ".") },
+		})
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if underHiddenFolder(drive, name, f) {
+				continue
+			}
+			fresh.put(IndexedFile{
+				ID:      joinID(name, f.ID),
+				Backend: name,
+				Path:    f.ID,
+				Name:    f.Name,
+				Type:    f.Type,
+				Size:    f.Size,
+				Date:    f.Date,
+			})
+		}
+	}
+
+	fresh.mu.Lock()
+	words, docs := fresh.words, fresh.docs
+	fresh.mu.Unlock()
+
+	index.mu.Lock()
+	index.words = words
+	index.docs = docs
+	index.mu.Unlock()
+}
+
+func saveSearchIndex() {
+	index.mu.RLock()
+	docs := make([]IndexedFile, 0, len(index.docs))
+	for _, doc := range index.docs {
+		docs = append(docs, doc)
+	}
+	index.mu.RUnlock()
+
+	data, err := json.Marshal(docs)
+	if err != nil {
+		return
+	}
+	os.WriteFile(searchIndexPath, data, 0644)
+}
+
+func loadSearchIndex() bool {
+	data, err := os.ReadFile(searchIndexPath)
+	if err != nil {
+		return false
+	}
+	var docs []IndexedFile
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return false
+	}
+	for _, doc := range docs {
+		index.put(doc)
+	}
+	return true
+}
+
+// startSearchIndexer loads a persisted index if available, otherwise
+// builds one from scratch, then keeps it fresh with a periodic rebuild
+// as a safety net for changes made outside this process. The initial
+// build runs in the background so a cold start with no persisted index
+// (or a large tree that needs a full remote walk) doesn't delay the
+// server from accepting requests; /search reports "not ready" until it
+// completes.
+func startSearchIndexer() {
+	go func() {
+		if !loadSearchIndex() {
+			buildSearchIndex()
+		}
+		index.markReady()
+		saveSearchIndex()
+
+		ticker := time.NewTicker(searchRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			buildSearchIndex()
+			saveSearchIndex()
+		}
+	}()
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,13 +25,10 @@ import (
 	"github.com/unrolled/render"
 
 	"github.com/xbsoftware/wfs"
-	local "github.com/xbsoftware/wfs-local"
 )
 
 var format = render.New()
 
-var drive wfs.Drive
-
 type FSFeatures struct {
 	Preview map[string]bool `json:"preview"`
 	Meta    map[string]bool `json:"meta"`
@@ -44,6 +46,9 @@ func main() {
 	flag.BoolVar(&Config.Readonly, "readonly", false, "readonly mode")
 	flag.Int64Var(&Config.UploadLimit, "limit", 10_000_000, "max file size to upload")
 	flag.StringVar(&Config.Server.Port, "port", ":3200", "port for web server")
+	flag.StringVar(&Config.FFmpegPath, "ffmpeg", "ffmpeg", "path to the ffmpeg binary, used for video previews")
+	flag.StringVar(&Config.FFprobePath, "ffprobe", "ffprobe", "path to the ffprobe binary, used for video previews")
+	flag.StringVar(&Config.PdftoppmPath, "pdftoppm", "pdftoppm", "path to the pdftoppm binary, used for PDF previews")
 	flag.Parse()
 
 	readConfig()
@@ -67,19 +72,15 @@ func main() {
 	if Config.Preview != "" {
 		features.Preview["document"] = true
 		features.Preview["code"] = true
+	} else {
+		features.Preview["video"] = true
+		if pdftoppmAvailable() {
+			features.Preview["document"] = true
+		}
 	}
 
 	// common drive access
-	var err error
-	driveConfig := wfs.DriveConfig{Verbose: true}
-	driveConfig.Operation = &wfs.OperationConfig{PreventNameCollision: true}
-	if Config.Readonly {
-		temp := wfs.Policy(&wfs.ReadOnlyPolicy{})
-		driveConfig.Policy = &temp
-	}
-
-	drive, err = local.NewLocalDrive(Config.Root, &driveConfig)
-	if err != nil {
+	if err := initDrives(); err != nil {
 		log.Fatal(err)
 	}
 
@@ -98,42 +99,74 @@ func main() {
 		r.Use(c.Handler)
 	}
 
-	r.Get("/files", func(w http.ResponseWriter, r *http.Request) {
-		search := r.URL.Query().Get("text")
-		data, err := drive.List("/", getListConfig(search))
+	listFiles := func(w http.ResponseWriter, r *http.Request, backend, path string) {
+		drive, ok := drives[backend]
+		if !ok {
+			format.Text(w, 500, fmt.Sprintf("unknown backend %q", backend))
+			return
+		}
 
+		search := r.URL.Query().Get("text")
+		data, err := drive.List(path, getListConfig(search))
 		if err != nil {
 			format.Text(w, 500, err.Error())
 			return
 		}
+		data, err = filterListing(drive, backend, path, data)
+		if err != nil {
+			format.Text(w, 403, err.Error())
+			return
+		}
+
+		format.JSON(w, 200, normalizeItems(backend, data))
+	}
 
-		err = format.JSON(w, 200, normalizeItems(data))
+	r.Get("/files", func(w http.ResponseWriter, r *http.Request) {
+		listFiles(w, r, defaultBackend, "/")
 	})
 
-	r.Get("/files/{path}", func(w http.ResponseWriter, r *http.Request) {
-		path, err := url.QueryUnescape(chi.URLParam(r, "path"))
+	// "/files/{backend}" is ambiguous with the legacy "/files/{path}" route
+	// from single-backend deployments: both are a single URL segment. If
+	// the segment names a configured backend, list its root; otherwise
+	// fall back to treating it as a URL-encoded path under defaultBackend,
+	// so existing clients that never knew about backends keep working.
+	r.Get("/files/{backend}", func(w http.ResponseWriter, r *http.Request) {
+		seg := chi.URLParam(r, "backend")
+		if _, ok := drives[seg]; ok {
+			listFiles(w, r, seg, "/")
+			return
+		}
+
+		path, err := url.QueryUnescape(seg)
 		if err != nil {
 			format.Text(w, 500, err.Error())
 			return
 		}
+		listFiles(w, r, defaultBackend, path)
+	})
 
-		search := r.URL.Query().Get("text")
-		data, err := drive.List(path, getListConfig(search))
-
+	r.Get("/files/{backend}/{path}", func(w http.ResponseWriter, r *http.Request) {
+		path, err := url.QueryUnescape(chi.URLParam(r, "path"))
 		if err != nil {
 			format.Text(w, 500, err.Error())
 			return
 		}
-
-		err = format.JSON(w, 200, normalizeItems(data))
+		listFiles(w, r, chi.URLParam(r, "backend"), path)
 	})
 
 	r.Put("/files/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, err := url.QueryUnescape(chi.URLParam(r, "id"))
+		rawID, err := url.QueryUnescape(chi.URLParam(r, "id"))
+		if err != nil {
+			format.JSON(w, 500, Response{Error: err.Error()})
+			return
+		}
+
+		drive, id, err := resolveDrive(r, rawID)
 		if err != nil {
 			format.JSON(w, 500, Response{Error: err.Error()})
 			return
 		}
+		backend, _ := splitID(rawID)
 
 		data := FileUpdate{}
 		err = parseForm(w, r, &data)
@@ -156,6 +189,11 @@ func main() {
 				return
 			}
 
+			if effectiveFolderConfig(drive, backend, path.Dir(id)).isReadonly() {
+				format.JSON(w, 500, Response{Error: "this folder is readonly"})
+				return
+			}
+
 			id, err = drive.Move(id, "", name)
 			if err != nil {
 				format.JSON(w, 500, Response{Error: err.Error()})
@@ -173,12 +211,12 @@ func main() {
 			return
 		}
 
-		format.JSON(w, 200, Response{Result: &Result{ID: info.ID, Name: info.Name}})
+		format.JSON(w, 200, Response{Result: &Result{ID: joinID(backend, info.ID), Name: info.Name}})
 	})
 
 	r.Put("/files", func(w http.ResponseWriter, r *http.Request) {
 		data := FileUpdate{}
-		err = parseForm(w, r, &data)
+		err := parseForm(w, r, &data)
 		if err != nil {
 			format.JSON(w, 500, ResponseMulti{Error: err.Error()})
 			return
@@ -192,38 +230,46 @@ func main() {
 			return
 		}
 
+		targetDrive, targetID, err := resolveDrive(r, to)
+		if err != nil {
+			format.JSON(w, 500, ResponseMulti{Error: err.Error()})
+			return
+		}
+		targetBackend, _ := splitID(to)
+
+		if effectiveFolderConfig(targetDrive, targetBackend, targetID).isReadonly() {
+			format.JSON(w, 500, ResponseMulti{Error: "this folder is readonly"})
+			return
+		}
+
 		result := make([]Result, 0)
 
 		switch operation {
-		case "move":
-			for _, id := range data.Ids {
-				id, err = drive.Move(id, to, "")
-				if err != nil {
-					format.JSON(w, 500, ResponseMulti{Error: err.Error()})
+		case "move", "copy":
+			for _, rawID := range data.Ids {
+				backend, _ := splitID(rawID)
+				if backend != targetBackend {
+					format.JSON(w, 500, ResponseMulti{Error: "moving files across backends is not supported"})
 					return
 				}
 
-				info, err := drive.Info(id)
-				if err != nil {
-					format.JSON(w, 500, ResponseMulti{Error: err.Error()})
-					return
+				var newID string
+				if operation == "move" {
+					newID, err = targetDrive.Move(idWithoutBackend(rawID), targetID, "")
+				} else {
+					newID, err = targetDrive.Copy(idWithoutBackend(rawID), targetID, "")
 				}
-				result = append(result, Result{ID: info.ID, Name: info.Name})
-			}
-		case "copy":
-			for _, id := range data.Ids {
-				id, err = drive.Copy(id, to, "")
 				if err != nil {
 					format.JSON(w, 500, ResponseMulti{Error: err.Error()})
 					return
 				}
 
-				info, err := drive.Info(id)
+				info, err := targetDrive.Info(newID)
 				if err != nil {
 					format.JSON(w, 500, ResponseMulti{Error: err.Error()})
 					return
 				}
-				result = append(result, Result{ID: info.ID, Name: info.Name})
+				result = append(result, Result{ID: joinID(targetBackend, info.ID), Name: info.Name})
 			}
 		default:
 			format.JSON(w, 500, ResponseMulti{Error: "operation is not supported"})
@@ -234,11 +280,18 @@ func main() {
 	})
 
 	r.Post("/files/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, err := url.QueryUnescape(chi.URLParam(r, "id"))
+		rawID, err := url.QueryUnescape(chi.URLParam(r, "id"))
+		if err != nil {
+			format.JSON(w, 500, Response{Error: err.Error()})
+			return
+		}
+
+		drive, id, err := resolveDrive(r, rawID)
 		if err != nil {
 			format.JSON(w, 500, Response{Error: err.Error()})
 			return
 		}
+		backend, _ := splitID(rawID)
 
 		data := NewFile{}
 		err = parseForm(w, r, &data)
@@ -254,6 +307,16 @@ func main() {
 			return
 		}
 
+		folderConfig := effectiveFolderConfig(drive, backend, id)
+		if folderConfig.isReadonly() {
+			format.JSON(w, 500, Response{Error: "this folder is readonly"})
+			return
+		}
+		if typ != "folder" && !folderConfig.extensionAllowed(name) {
+			format.JSON(w, 500, Response{Error: "file extension is not allowed in this folder"})
+			return
+		}
+
 		id, err = drive.Make(id, name, typ == "folder")
 		if err != nil {
 			format.JSON(w, 500, Response{Error: err.Error()})
@@ -266,12 +329,12 @@ func main() {
 			return
 		}
 
-		format.JSON(w, 200, Response{Result: &Result{ID: info.ID, Name: info.Name}})
+		format.JSON(w, 200, Response{Result: &Result{ID: joinID(backend, info.ID), Name: info.Name}})
 	})
 
 	r.Delete("/files", func(w http.ResponseWriter, r *http.Request) {
 		data := FileUpdate{}
-		err = parseForm(w, r, &data)
+		err := parseForm(w, r, &data)
 		if err != nil {
 			format.JSON(w, 500, ResponseMulti{Error: err.Error()})
 			return
@@ -282,120 +345,252 @@ func main() {
 			return
 		}
 
-		for _, id := range data.Ids {
-			err = drive.Remove(id)
+		for _, rawID := range data.Ids {
+			drive, id, err := resolveDrive(r, rawID)
 			if err != nil {
 				format.JSON(w, 500, ResponseMulti{Error: err.Error()})
 				return
 			}
+			backend, _ := splitID(rawID)
+			if effectiveFolderConfig(drive, backend, path.Dir(id)).isReadonly() {
+				format.JSON(w, 500, ResponseMulti{Error: "this folder is readonly"})
+				return
+			}
+			if err := drive.Remove(id); err != nil {
+				format.JSON(w, 500, ResponseMulti{Error: err.Error()})
+				return
+			}
 		}
 
 		format.JSON(w, 200, ResponseMulti{})
 	})
 
-	r.Get("/direct", func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Query().Get("id")
-		if id == "" {
-			format.Text(w, 500, "id not provided")
-			return
-		}
+	r.Get("/direct", directHandler)
+	r.Head("/direct", directHandler)
 
-		info, err := drive.Info(id)
-		if err != nil {
-			format.Text(w, 500, "Access denied")
-			return
-		}
+	r.Post("/upload/{backend}", uploadHandler)
+	r.Post("/upload", uploadHandler)
 
-		data, err := drive.Read(id)
-		if err != nil {
-			format.Text(w, 500, "Access denied")
-			return
-		}
+	r.Get("/archive", archiveListHandler)
+	r.Post("/extract", extractHandler)
+	r.Post("/archive", packArchiveHandler)
 
-		disposition := "inline"
-		_, ok := r.URL.Query()["download"]
-		if ok {
-			disposition = "attachment"
-		}
+	registerShareRoutes(r)
+	startShareSweeper()
+
+	registerTusRoutes(r)
+	startTusSweeper()
+
+	r.Get("/search", searchHandler)
+	startSearchIndexer()
+
+	r.Get("/metrics", metricsHandler)
 
-		w.Header().Set("Content-Disposition", disposition+"; filename=\""+info.Name+"\"")
-		http.ServeContent(w, r, "", time.Now(), data)
+	r.Get("/info", getInfo)
+	r.Get("/info/{id}", getMetaInfo)
+	r.Get("/preview", getFilePreview)
+
+	r.Get("/icons/{size}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		size := chi.URLParam(r, "size")
+
+		http.ServeFile(w, r, getIconURL(name, size))
 	})
 
-	r.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
-		// buffer for file parsing, this is NOT the max upload size
-		var limit = int64(32 << 20) // default is 32MB
-		if Config.UploadLimit < limit {
-			limit = Config.UploadLimit
-		}
+	log.Printf("Starting webserver at port " + Config.Server.Port)
+	http.ListenAndServe(Config.Server.Port, r)
+}
 
-		// this one limit max upload size
-		r.Body = http.MaxBytesReader(w, r.Body, Config.UploadLimit)
-		r.ParseMultipartForm(limit)
+// directHandler streams raw file content for preview/download. It sets a
+// strong ETag and the real modification time so http.ServeContent can
+// answer If-None-Match, If-Modified-Since, If-Range and byte-range
+// requests on its own. HEAD is answered straight from info, without ever
+// calling drive.Read - on the S3 backend that call issues a second
+// HeadObject request (through Info), which a HEAD request has no business
+// paying for.
+func directHandler(w http.ResponseWriter, r *http.Request) {
+	rawID := r.URL.Query().Get("id")
+	if rawID == "" {
+		format.Text(w, 500, "id not provided")
+		return
+	}
 
-		file, handler, err := r.FormFile("file")
-		if err != nil {
-			format.JSON(w, 500, Response{Error: "The file has not been uploaded"})
+	drive, id, err := resolveDrive(r, rawID)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+
+	if entry := r.URL.Query().Get("archive_entry"); entry != "" {
+		directArchiveEntry(w, r, drive, id, entry)
+		return
+	}
+
+	info, err := drive.Info(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+
+	disposition := "inline"
+	_, ok := r.URL.Query()["download"]
+	if ok {
+		disposition = "attachment"
+	}
+
+	modtime := time.Unix(info.Date, 0)
+	etag := directETag(rawID, info)
+
+	w.Header().Set("Content-Disposition", disposition+"; filename=\""+info.Name+"\"")
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+
+	if r.Method == http.MethodHead {
+		if directNotModified(r, etag, modtime) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
-		defer file.Close()
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-		base := r.URL.Query().Get("id")
+	data, err := drive.Read(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
 
-		filename := r.Form.Get("name")
-		if filename == "" {
-			filename = handler.Filename
-		}
+	http.ServeContent(w, r, "", modtime, data)
+}
 
-		parts := strings.Split(filename, "/")
-		if len(parts) > 1 {
-			for _, p := range parts[:len(parts)-1] {
-				if !drive.Exists(base + "/" + p) {
-					id, err := drive.Make(base, p, true)
-					if err != nil {
-						format.JSON(w, 500, Response{Error: err.Error()})
-						return
-					}
-					base = id
-				} else {
-					base = base + "/" + p
-				}
+// directNotModified replicates the If-None-Match / If-Modified-Since
+// checks http.ServeContent performs internally, so the HEAD fast path in
+// directHandler can answer conditional requests without opening the file.
+func directNotModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, want := range strings.Split(inm, ",") {
+			if want := strings.TrimSpace(want); want == etag || want == "*" {
+				return true
 			}
 		}
-
-		fileID, err := drive.Make(base, parts[len(parts)-1], false)
-		if err != nil {
-			format.JSON(w, 500, Response{Error: "Access Denied"})
-			return
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil && !modtime.Truncate(time.Second).After(t) {
+			return true
 		}
+	}
+	return false
+}
 
-		err = drive.Write(fileID, file)
-		if err != nil {
-			format.JSON(w, 500, Response{Error: "Access Denied"})
-			return
-		}
+// directETag builds a strong ETag out of the file's identity, size and
+// modification time, so it changes whenever the content could have
+// changed but stays stable across requests otherwise.
+func directETag(rawID string, info wfs.File) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", rawID, info.Size, info.Date)))
+	return `"` + hex.EncodeToString(sum[:])[:32] + `"`
+}
 
-		info, err := drive.Info(fileID)
-		if err != nil {
-			format.JSON(w, 500, Response{Error: "Access Denied"})
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	rawBase := r.URL.Query().Get("id")
+	drive, base, err := resolveDrive(r, rawBase)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+	backend, _ := splitID(rawBase)
+	if chi.URLParam(r, "backend") != "" {
+		backend = chi.URLParam(r, "backend")
+	}
+
+	// The folder's upload limit override must be known before the body is
+	// read, or an oversized body is rejected by MaxBytesReader/the
+	// multipart parser before the override ever gets a chance to apply.
+	folderConfig := effectiveFolderConfig(drive, backend, base)
+	if folderConfig.isReadonly() {
+		format.JSON(w, 500, Response{Error: "this folder is readonly"})
+		return
+	}
+
+	uploadLimit := Config.UploadLimit
+	if folderConfig.UploadLimit > 0 {
+		uploadLimit = folderConfig.UploadLimit
+	}
+
+	// buffer for file parsing, this is NOT the max upload size
+	var limit = int64(32 << 20) // default is 32MB
+	if uploadLimit < limit {
+		limit = uploadLimit
+	}
+
+	// this one limit max upload size
+	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	r.ParseMultipartForm(limit)
+
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "The file has not been uploaded"})
+		return
+	}
+	defer file.Close()
+
+	filename := r.Form.Get("name")
+	if filename == "" {
+		filename = handler.Filename
+	}
+
+	if !folderConfig.extensionAllowed(filename) {
+		format.JSON(w, 500, Response{Error: "file extension is not allowed in this folder"})
+		return
+	}
+	if handler.Size > uploadLimit {
+		format.JSON(w, 500, Response{Error: "file exceeds this folder's upload limit"})
+		return
+	}
+	if folderConfig.QuotaBytes > 0 {
+		var used int64
+		if _, _, err := checkoutDir(drive, base, &used); err == nil && used+handler.Size > folderConfig.QuotaBytes {
+			format.JSON(w, 500, Response{Error: "this folder's quota has been reached"})
 			return
 		}
-		format.JSON(w, 200, Response{Result: &Result{ID: info.ID, Name: info.Name}})
-	})
+	}
 
-	r.Get("/info", getInfo)
-	r.Get("/info/{id}", getMetaInfo)
-	r.Get("/preview", getFilePreview)
+	parts := strings.Split(filename, "/")
+	if len(parts) > 1 {
+		for _, p := range parts[:len(parts)-1] {
+			if !drive.Exists(base + "/" + p) {
+				id, err := drive.Make(base, p, true)
+				if err != nil {
+					format.JSON(w, 500, Response{Error: err.Error()})
+					return
+				}
+				base = id
+			} else {
+				base = base + "/" + p
+			}
+		}
+	}
 
-	r.Get("/icons/{size}/{name}", func(w http.ResponseWriter, r *http.Request) {
-		name := chi.URLParam(r, "name")
-		size := chi.URLParam(r, "size")
+	fileID, err := drive.Make(base, parts[len(parts)-1], false)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access Denied"})
+		return
+	}
 
-		http.ServeFile(w, r, getIconURL(name, size))
-	})
+	err = drive.Write(fileID, file)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access Denied"})
+		return
+	}
 
-	log.Printf("Starting webserver at port " + Config.Server.Port)
-	http.ListenAndServe(Config.Server.Port, r)
+	info, err := drive.Info(fileID)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access Denied"})
+		return
+	}
+	format.JSON(w, 200, Response{Result: &Result{ID: joinID(backend, info.ID), Name: info.Name}})
 }
 
 func parseForm(w http.ResponseWriter, r *http.Request, o interface{}) error {
@@ -406,17 +601,25 @@ func parseForm(w http.ResponseWriter, r *http.Request, o interface{}) error {
 	return err
 }
 
-func normalizeItems(files []wfs.File) []File {
+func idWithoutBackend(id string) string {
+	_, inner := splitID(id)
+	return inner
+}
+
+func normalizeItems(backend string, files []wfs.File) []File {
+	drive := drives[backend]
+
 	out := make([]File, 0)
 	for _, file := range files {
 		target := File{}
 		temp, _ := json.Marshal(file)
 		json.Unmarshal(temp, &target)
+		target.ID = joinID(backend, file.ID)
 
 		if target.Type == "folder" {
 			target.Size = nil
 
-			dir, err := drive.List(target.ID, &wfs.ListConfig{
+			dir, err := drive.List(file.ID, &wfs.ListConfig{
 				SubFolders: false,
 				Exclude:    func(name string) bool { return strings.HasPrefix(name, ".") },
 			})
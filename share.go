@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// shareStoreDir holds one JSON file per active share link, named after its
+// token. Shares are metadata only - the shared file itself is always read
+// through the owning backend's Drive, so sharing works the same way
+// regardless of which backend the file lives on.
+var shareStoreDir = filepath.Join(os.TempDir(), "fm-shares")
+
+// shareSweepInterval is how often expired shares are purged in the
+// background.
+const shareSweepInterval = 10 * time.Minute
+
+type ShareMeta struct {
+	Token         string `json:"token"`
+	FileID        string `json:"file_id"`
+	SHA256        string `json:"sha256,omitempty"`
+	Mimetype      string `json:"mimetype,omitempty"`
+	Size          int64  `json:"size"`
+	Expiry        int64  `json:"expiry,omitempty"`
+	DeleteKey     string `json:"delete_key"`
+	MaxDownloads  int    `json:"max_downloads,omitempty"`
+	DownloadCount int    `json:"download_count"`
+	PasswordHash  string `json:"password_hash,omitempty"`
+}
+
+type ShareRequest struct {
+	ID           string
+	ExpiresIn    int64
+	MaxDownloads int
+	Password     string
+}
+
+type ShareResponse struct {
+	Token     string `json:"token"`
+	URL       string `json:"url"`
+	DeleteKey string `json:"delete_key"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+func registerShareRoutes(r chi.Router) {
+	r.Post("/share", createShare)
+	r.Get("/s/{token}", serveShare)
+	r.Delete("/s/{token}", revokeShare)
+}
+
+func createShare(w http.ResponseWriter, r *http.Request) {
+	data := ShareRequest{}
+	if err := parseForm(w, r, &data); err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+	if data.ID == "" {
+		format.JSON(w, 500, Response{Error: "'id' parameter must be provided"})
+		return
+	}
+
+	drive, id, err := resolveDrive(r, data.ID)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+
+	info, err := drive.Info(id)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access denied"})
+		return
+	}
+
+	content, err := drive.Read(id)
+	if err != nil {
+		format.JSON(w, 500, Response{Error: "Access denied"})
+		return
+	}
+	sum := sha256.New()
+	io.Copy(sum, content)
+
+	token, err := randomToken()
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+	deleteKey, err := randomToken()
+	if err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+
+	meta := ShareMeta{
+		Token:        token,
+		FileID:       data.ID,
+		SHA256:       hex.EncodeToString(sum.Sum(nil)),
+		Size:         info.Size,
+		DeleteKey:    deleteKey,
+		MaxDownloads: data.MaxDownloads,
+	}
+	if data.ExpiresIn > 0 {
+		meta.Expiry = time.Now().Unix() + data.ExpiresIn
+	}
+	if data.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
+		if err != nil {
+			format.JSON(w, 500, Response{Error: err.Error()})
+			return
+		}
+		meta.PasswordHash = string(hash)
+	}
+
+	if err := saveShareMeta(&meta); err != nil {
+		format.JSON(w, 500, Response{Error: err.Error()})
+		return
+	}
+
+	format.JSON(w, 200, ShareResponse{
+		Token:     token,
+		URL:       "/s/" + token,
+		DeleteKey: deleteKey,
+		ExpiresAt: meta.Expiry,
+	})
+}
+
+func serveShare(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	meta, err := loadShareMeta(token)
+	if err != nil {
+		format.Text(w, 404, "share not found")
+		return
+	}
+
+	if meta.Expiry != 0 && time.Now().Unix() > meta.Expiry {
+		removeShareMeta(token)
+		format.Text(w, 404, "share not found")
+		return
+	}
+	if meta.MaxDownloads > 0 && meta.DownloadCount >= meta.MaxDownloads {
+		format.Text(w, 410, "share download limit reached")
+		return
+	}
+	if meta.PasswordHash != "" {
+		password := r.Header.Get("X-Share-Password")
+		if bcrypt.CompareHashAndPassword([]byte(meta.PasswordHash), []byte(password)) != nil {
+			format.Text(w, 401, "password required")
+			return
+		}
+	}
+
+	drive, id, err := resolveDrive(r, meta.FileID)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+
+	info, err := drive.Info(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+
+	data, err := drive.Read(id)
+	if err != nil {
+		format.Text(w, 500, "Access denied")
+		return
+	}
+
+	disposition := "inline"
+	if _, ok := r.URL.Query()["download"]; ok {
+		disposition = "attachment"
+	}
+
+	// The limit check and increment must happen as one atomic unit, or
+	// concurrent requests for the same token can all read the same
+	// pre-increment count and all pass the max_downloads check.
+	unlock := lockShareDownload(token)
+	defer unlock()
+
+	current, err := loadShareMeta(token)
+	if err != nil {
+		format.Text(w, 404, "share not found")
+		return
+	}
+	if current.MaxDownloads > 0 && current.DownloadCount >= current.MaxDownloads {
+		format.Text(w, 410, "share download limit reached")
+		return
+	}
+	current.DownloadCount++
+	if err := saveShareMeta(current); err != nil {
+		log.Println(err.Error())
+	}
+
+	w.Header().Set("Content-Disposition", disposition+"; filename=\""+info.Name+"\"")
+	w.Header().Set("ETag", directETag(meta.FileID, info))
+	http.ServeContent(w, r, "", time.Unix(info.Date, 0), data)
+}
+
+func revokeShare(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	key := r.URL.Query().Get("key")
+
+	meta, err := loadShareMeta(token)
+	if err != nil {
+		format.Text(w, 404, "share not found")
+		return
+	}
+	if meta.DeleteKey != key {
+		format.Text(w, 403, "invalid delete key")
+		return
+	}
+
+	if err := removeShareMeta(token); err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+	format.JSON(w, 200, Response{})
+}
+
+// shareDownloadLocks serializes the check-then-increment of DownloadCount
+// per token, so concurrent requests against the same share can't all read
+// the same pre-increment count and all pass the max_downloads check.
+var shareDownloadLocks = struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+// lockShareDownload locks the mutex for token, creating it on first use,
+// and returns a func to unlock it.
+func lockShareDownload(token string) func() {
+	shareDownloadLocks.mu.Lock()
+	l, ok := shareDownloadLocks.locks[token]
+	if !ok {
+		l = &sync.Mutex{}
+		shareDownloadLocks.locks[token] = l
+	}
+	shareDownloadLocks.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// isValidRandomToken reports whether token has the exact shape produced
+// by randomToken (32 lowercase hex chars). Any handler that takes a
+// randomToken-generated id straight from the URL and uses it to build a
+// filesystem path (share tokens, tus upload ids) must reject anything
+// else first, or the id can be used as a path traversal payload.
+func isValidRandomToken(token string) bool {
+	if len(token) != 32 {
+		return false
+	}
+	for _, c := range token {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func shareMetaPath(token string) string {
+	return filepath.Join(shareStoreDir, token+".json")
+}
+
+func saveShareMeta(meta *ShareMeta) error {
+	if err := os.MkdirAll(shareStoreDir, 0777); err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(shareMetaPath(meta.Token), data, 0644)
+}
+
+func loadShareMeta(token string) (*ShareMeta, error) {
+	if !isValidRandomToken(token) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(shareMetaPath(token))
+	if err != nil {
+		return nil, err
+	}
+	meta := &ShareMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func removeShareMeta(token string) error {
+	if !isValidRandomToken(token) {
+		return nil
+	}
+	err := os.Remove(shareMetaPath(token))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// startShareSweeper periodically removes share metadata whose expiry has
+// passed, so shares with no further requests still get cleaned up.
+func startShareSweeper() {
+	go func() {
+		ticker := time.NewTicker(shareSweepInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			entries, err := os.ReadDir(shareStoreDir)
+			if err != nil {
+				continue
+			}
+			now := time.Now().Unix()
+			for _, entry := range entries {
+				token := entry.Name()[:len(entry.Name())-len(filepath.Ext(entry.Name()))]
+				meta, err := loadShareMeta(token)
+				if err != nil {
+					continue
+				}
+				if meta.Expiry != 0 && now > meta.Expiry {
+					removeShareMeta(token)
+				}
+			}
+		}
+	}()
+}
@@ -4,20 +4,35 @@ import (
 	"net/http"
 )
 
+// unlimitedFree is the sentinel wfs.Drive.Stats implementations return as
+// "free" for backends with no fixed capacity (e.g. S3). It must never be
+// added to another backend's real free-space value, since that overflows
+// uint64 and wraps to a small, garbage number.
+const unlimitedFree = ^uint64(0)
+
 type FSStats struct {
-	Free  uint64 `json:"free"`
-	Total uint64 `json:"total"`
-	Used  uint64 `json:"used"`
+	Free      uint64 `json:"free"`
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Unlimited bool   `json:"unlimited,omitempty"`
 }
 
 func getInfo(w http.ResponseWriter, r *http.Request) {
-	used, free, err := drive.Stats()
-	if err != nil {
-		format.JSON(w, 500, Response{Error: err.Error()})
-		return
+	var used, free uint64
+	unlimited := false
+	for _, drive := range drives {
+		u, f, err := drive.Stats()
+		if err != nil {
+			format.JSON(w, 500, Response{Error: err.Error()})
+			return
+		}
+		used += u
+		if f == unlimitedFree {
+			unlimited = true
+			continue
+		}
+		free += f
 	}
 
-	total := free + used
-
-	format.JSON(w, 200, FSStats{Used: used, Total: total})
+	format.JSON(w, 200, FSStats{Used: used, Free: free, Total: free + used, Unlimited: unlimited})
 }
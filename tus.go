@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const tusVersion = "1.0.0"
+const tusExtensions = "creation,expiration,checksum,termination"
+const tusUploadTTL = 24 * time.Hour
+const tusSweepInterval = 30 * time.Minute
+
+// tusUploadsDir holds in-progress chunked uploads: a "{id}.bin" payload
+// file next to a "{id}.json" sidecar recording where the finished file
+// should be written and how far the upload has progressed.
+func tusUploadsDir() string {
+	return filepath.Join(Config.Root, ".uploads")
+}
+
+type tusUpload struct {
+	ID       string            `json:"id"`
+	Target   string            `json:"target"`
+	Name     string            `json:"name"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Created  int64             `json:"created"`
+	Expires  int64             `json:"expires"`
+}
+
+func registerTusRoutes(r chi.Router) {
+	r.Route("/uploads", func(r chi.Router) {
+		r.Options("/", tusDiscover)
+		r.Post("/", tusCreate)
+		r.Options("/{id}", tusDiscover)
+		r.Head("/{id}", tusHead)
+		r.Patch("/{id}", tusPatch)
+		r.Delete("/{id}", tusTerminate)
+	})
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+}
+
+func tusDiscover(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(Config.UploadLimit, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tusCreate(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		format.Text(w, 400, "Upload-Length header must be provided")
+		return
+	}
+
+	metadata := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	name := metadata["filename"]
+	if name == "" {
+		format.Text(w, 400, "Upload-Metadata must include a filename entry")
+		return
+	}
+
+	target := r.URL.Query().Get("id")
+	drive, parent, err := resolveDrive(r, target)
+	if err != nil {
+		format.Text(w, 400, err.Error())
+		return
+	}
+	backend, _ := splitID(target)
+
+	// Enforce the same per-folder overrides /upload does, so a chunked
+	// upload can't be used to bypass a .fmconfig.yml that locks the
+	// folder down.
+	folderConfig := effectiveFolderConfig(drive, backend, parent)
+	if folderConfig.isReadonly() {
+		format.Text(w, 500, "this folder is readonly")
+		return
+	}
+	if !folderConfig.extensionAllowed(name) {
+		format.Text(w, 500, "file extension is not allowed in this folder")
+		return
+	}
+
+	uploadLimit := Config.UploadLimit
+	if folderConfig.UploadLimit > 0 {
+		uploadLimit = folderConfig.UploadLimit
+	}
+	if length > uploadLimit {
+		format.Text(w, http.StatusRequestEntityTooLarge, "upload exceeds this folder's upload limit")
+		return
+	}
+	if folderConfig.QuotaBytes > 0 {
+		var used int64
+		if _, _, err := checkoutDir(drive, parent, &used); err == nil && used+length > folderConfig.QuotaBytes {
+			format.Text(w, 500, "this folder's quota has been reached")
+			return
+		}
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	now := time.Now()
+	up := tusUpload{
+		ID:       id,
+		Target:   target,
+		Name:     name,
+		Length:   length,
+		Metadata: metadata,
+		Created:  now.Unix(),
+		Expires:  now.Add(tusUploadTTL).Unix(),
+	}
+
+	if err := os.MkdirAll(tusUploadsDir(), 0777); err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+	if f, err := os.Create(tusDataPath(id)); err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	} else {
+		f.Close()
+	}
+	if err := saveTusUpload(&up); err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/"+id)
+	w.Header().Set("Upload-Expires", time.Unix(up.Expires, 0).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func tusHead(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	up, err := loadTusUpload(chi.URLParam(r, "id"))
+	if err != nil {
+		format.Text(w, 404, "upload not found")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	w.Header().Set("Upload-Expires", time.Unix(up.Expires, 0).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func tusPatch(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		format.Text(w, 415, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	up, err := loadTusUpload(id)
+	if err != nil {
+		format.Text(w, 404, "upload not found")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		format.Text(w, 400, "Upload-Offset header must be provided")
+		return
+	}
+	if offset != up.Offset {
+		format.Text(w, 409, "Upload-Offset does not match the current offset")
+		return
+	}
+
+	f, err := os.OpenFile(tusDataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	var checksum hash.Hash
+	var checksumWant string
+	if alg, want, ok := parseUploadChecksum(r.Header.Get("Upload-Checksum")); ok {
+		checksum, err = checksumHasher(alg)
+		if err != nil {
+			format.Text(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		checksumWant = want
+	}
+
+	limit := up.Length - offset
+	body := io.LimitReader(r.Body, limit+1)
+	var written int64
+	var writeErr error
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if written+int64(n) > limit {
+				writeErr = fmt.Errorf("chunk exceeds declared upload length")
+				break
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				writeErr = err
+				break
+			}
+			if checksum != nil {
+				checksum.Write(buf[:n])
+			}
+			written += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			writeErr = readErr
+			break
+		}
+	}
+	if writeErr != nil {
+		format.Text(w, 500, writeErr.Error())
+		return
+	}
+
+	if checksum != nil {
+		got := base64.StdEncoding.EncodeToString(checksum.Sum(nil))
+		if got != checksumWant {
+			format.Text(w, 460, "checksum mismatch")
+			return
+		}
+	}
+
+	up.Offset += written
+	if err := saveTusUpload(up); err != nil {
+		format.Text(w, 500, err.Error())
+		return
+	}
+
+	if up.Offset >= up.Length {
+		if err := finishTusUpload(r, up); err != nil {
+			format.Text(w, 500, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tusTerminate(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	removeTusUpload(chi.URLParam(r, "id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a completed upload into place via the target
+// backend's Drive and cleans up the staged files.
+func finishTusUpload(r *http.Request, up *tusUpload) error {
+	drive, parent, err := resolveDrive(r, up.Target)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(tusDataPath(up.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	id, err := drive.Make(parent, up.Name, false)
+	if err != nil {
+		return err
+	}
+	if err := drive.Write(id, f); err != nil {
+		return err
+	}
+
+	removeTusUpload(up.ID)
+	return nil
+}
+
+func tusMetaPath(id string) string {
+	return filepath.Join(tusUploadsDir(), id+".json")
+}
+
+func tusDataPath(id string) string {
+	return filepath.Join(tusUploadsDir(), id+".bin")
+}
+
+func saveTusUpload(up *tusUpload) error {
+	data, err := json.Marshal(up)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tusMetaPath(up.ID), data, 0644)
+}
+
+func loadTusUpload(id string) (*tusUpload, error) {
+	if !isValidRandomToken(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(tusMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	up := &tusUpload{}
+	if err := json.Unmarshal(data, up); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+func removeTusUpload(id string) {
+	if !isValidRandomToken(id) {
+		return
+	}
+	os.Remove(tusMetaPath(id))
+	os.Remove(tusDataPath(id))
+}
+
+func parseUploadMetadata(header string) map[string]string {
+	out := map[string]string{}
+	if header == "" {
+		return out
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		out[parts[0]] = string(value)
+	}
+	return out
+}
+
+func parseUploadChecksum(header string) (alg string, value string, ok bool) {
+	if header == "" {
+		return "", "", false
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func checksumHasher(alg string) (hash.Hash, error) {
+	switch alg {
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", alg)
+	}
+}
+
+// startTusSweeper periodically removes staged uploads that were never
+// completed and have passed their expiration time.
+func startTusSweeper() {
+	go func() {
+		ticker := time.NewTicker(tusSweepInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			entries, err := os.ReadDir(tusUploadsDir())
+			if err != nil {
+				continue
+			}
+			now := time.Now().Unix()
+			for _, entry := range entries {
+				if !strings.HasSuffix(entry.Name(), ".json") {
+					continue
+				}
+				id := strings.TrimSuffix(entry.Name(), ".json")
+				up, err := loadTusUpload(id)
+				if err != nil {
+					continue
+				}
+				if now > up.Expires {
+					removeTusUpload(id)
+				}
+			}
+		}
+	}()
+}
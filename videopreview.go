@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const maxPreviewWorkers = 4
+
+var previewGroup singleflight.Group
+var previewSem = make(chan struct{}, maxPreviewWorkers)
+
+func pdftoppmAvailable() bool {
+	_, err := exec.LookPath(Config.PdftoppmPath)
+	return err == nil
+}
+
+// queuePreviewGeneration runs generate() on the bounded worker pool,
+// coalescing concurrent requests for the same key via singleflight so a
+// burst of polls for one file only renders it once. The caller doesn't
+// wait for the result - it has already replied 202 Accepted.
+func queuePreviewGeneration(key string, preview string, generate func() (string, error)) {
+	go func() {
+		previewGroup.Do(key, func() (interface{}, error) {
+			previewSem <- struct{}{}
+			defer func() { <-previewSem }()
+
+			ext, err := generate()
+			if err != nil {
+				previewMetrics.failed.Add(1)
+				os.WriteFile(preview+".jpg", []byte{}, 0664)
+				return "", err
+			}
+			previewMetrics.generated.Add(1)
+			return ext, nil
+		})
+	}()
+}
+
+// getVideoPreview extracts a single frame at 10% of the video's duration
+// (see videoThumbnailOffset) and scales it down, using ffprobe for
+// duration and ffmpeg for the actual frame extraction. The offset is not
+// configurable. The source is read through the Drive so this works for
+// any backend.
+func getVideoPreview(source io.Reader, width, height int, preview string) (string, error) {
+	tmp, err := os.CreateTemp("", "fm-video-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, source); err != nil {
+		return "", err
+	}
+
+	offset := videoThumbnailOffset(tmp.Name())
+
+	out, err := os.CreateTemp(filepath.Dir(preview), "fm-preview-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	cmd := exec.Command(Config.FFmpegPath,
+		"-ss", fmt.Sprintf("%.2f", offset),
+		"-i", tmp.Name(),
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-y", out.Name(),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("ffmpeg: %w: %s", err, output)
+	}
+
+	// Render to a temp file and rename into place so a client polling
+	// /preview never observes a partially written file as a cache hit.
+	if err := os.Rename(out.Name(), preview+".jpg"); err != nil {
+		return "", err
+	}
+	return ".jpg", nil
+}
+
+// videoThumbnailOffset asks ffprobe for the duration and returns 10% of
+// it, falling back to a fixed offset when duration can't be determined.
+func videoThumbnailOffset(path string) float64 {
+	cmd := exec.Command(Config.FFprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 1
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || duration <= 0 {
+		return 1
+	}
+	return duration * 0.1
+}
+
+// getDocumentPreview renders the first page of a PDF via pdftoppm.
+func getDocumentPreview(source io.Reader, width int, preview string) (string, error) {
+	tmp, err := os.CreateTemp("", "fm-doc-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, source); err != nil {
+		return "", err
+	}
+
+	outBase, err := os.CreateTemp(filepath.Dir(preview), "fm-preview-*")
+	if err != nil {
+		return "", err
+	}
+	outBase.Close()
+	os.Remove(outBase.Name())
+	defer os.Remove(outBase.Name() + ".jpg")
+
+	cmd := exec.Command(Config.PdftoppmPath,
+		"-jpeg", "-f", "1", "-singlefile",
+		"-scale-to", strconv.Itoa(width),
+		tmp.Name(), outBase.Name(),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pdftoppm: %w: %s", err, out)
+	}
+
+	// Render to a temp file and rename into place so a client polling
+	// /preview never observes a partially written file as a cache hit.
+	if err := os.Rename(outBase.Name()+".jpg", preview+".jpg"); err != nil {
+		return "", err
+	}
+	return ".jpg", nil
+}
+
+func respondPreviewPending(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "3")
+	format.Text(w, http.StatusAccepted, "preview is being generated")
+}
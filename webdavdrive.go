@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/studio-b12/gowebdav"
+
+	"github.com/xbsoftware/wfs"
+)
+
+// webdavDrive implements wfs.Drive against a remote WebDAV server, letting
+// the file manager front an external WebDAV share the same way it fronts
+// the local filesystem.
+type webdavDrive struct {
+	client *gowebdav.Client
+}
+
+func newWebDAVDrive(b BackendConfig, driveConfig *wfs.DriveConfig) (wfs.Drive, error) {
+	if b.URL == "" {
+		return nil, fmt.Errorf("webdav backend requires a url")
+	}
+
+	client := gowebdav.NewClient(b.URL, b.User, b.Password)
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+
+	return &webdavDrive{client: client}, nil
+}
+
+func (d *webdavDrive) clean(id string) string {
+	return path.Clean("/" + id)
+}
+
+func (d *webdavDrive) List(id string, cfg *wfs.ListConfig) ([]wfs.File, error) {
+	out := make([]wfs.File, 0)
+
+	var walk func(id string) error
+	walk = func(id string) error {
+		entries, err := d.client.ReadDir(d.clean(id))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			childID := path.Join(id, entry.Name())
+			if cfg != nil {
+				if cfg.Exclude != nil && cfg.Exclude(entry.Name()) {
+					continue
+				}
+				if cfg.Include != nil && !cfg.Include(entry.Name()) {
+					continue
+				}
+			}
+
+			if entry.IsDir() {
+				out = append(out, wfs.File{ID: childID, Name: entry.Name(), Type: "folder"})
+				if cfg != nil && cfg.SubFolders {
+					if err := walk(childID); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			out = append(out, wfs.File{
+				ID:   childID,
+				Name: entry.Name(),
+				Type: fileType(entry.Name()),
+				Size: entry.Size(),
+				Date: entry.ModTime().Unix(),
+			})
+		}
+		return nil
+	}
+
+	if err := walk(id); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (d *webdavDrive) Info(id string) (wfs.File, error) {
+	stat, err := d.client.Stat(d.clean(id))
+	if err != nil {
+		return wfs.File{}, err
+	}
+
+	typ := "file"
+	if stat.IsDir() {
+		typ = "folder"
+	} else {
+		typ = fileType(stat.Name())
+	}
+
+	return wfs.File{
+		ID:   id,
+		Name: stat.Name(),
+		Type: typ,
+		Size: stat.Size(),
+		Date: stat.ModTime().Unix(),
+	}, nil
+}
+
+func (d *webdavDrive) Read(id string) (io.ReadSeeker, error) {
+	data, err := d.client.Read(d.clean(id))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (d *webdavDrive) Write(id string, data io.Reader) error {
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	return d.client.Write(d.clean(id), body, 0644)
+}
+
+func (d *webdavDrive) Make(id string, name string, isFolder bool) (string, error) {
+	childID := path.Join(id, name)
+	if isFolder {
+		if err := d.client.MkdirAll(d.clean(childID), 0755); err != nil {
+			return "", err
+		}
+		return childID, nil
+	}
+
+	if err := d.client.Write(d.clean(childID), []byte{}, 0644); err != nil {
+		return "", err
+	}
+	return childID, nil
+}
+
+func (d *webdavDrive) Copy(id string, target string, newName string) (string, error) {
+	name := newName
+	if name == "" {
+		name = path.Base(id)
+	}
+	dst := path.Join(target, name)
+	if err := d.client.Copy(d.clean(id), d.clean(dst), true); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (d *webdavDrive) Move(id string, target string, newName string) (string, error) {
+	name := newName
+	if name == "" {
+		name = path.Base(id)
+	}
+	dst := path.Join(target, name)
+	if err := d.client.Rename(d.clean(id), d.clean(dst), true); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (d *webdavDrive) Remove(id string) error {
+	return d.client.RemoveAll(d.clean(id))
+}
+
+func (d *webdavDrive) Exists(id string) bool {
+	_, err := d.client.Stat(d.clean(id))
+	return err == nil
+}
+
+func (d *webdavDrive) Stats() (uint64, uint64, error) {
+	// WebDAV has no standard quota discovery endpoint; report zero usage
+	// rather than guessing, same as servers that don't expose RFC 4331.
+	return 0, 0, nil
+}